@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// overrideState is the runtime-mutable form of the -override flag: an
+// admin can flip it on for a bounded TTL via POST /admin/override so a
+// known-bad path can be waved through without editing the flag and
+// restarting the process.
+type overrideState struct {
+	mu      sync.Mutex
+	enabled bool
+	timer   *time.Timer
+}
+
+var overrideSt = &overrideState{}
+
+func (o *overrideState) set(enabled bool, ttl time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.timer != nil {
+		o.timer.Stop()
+		o.timer = nil
+	}
+	o.enabled = enabled
+	if enabled && ttl > 0 {
+		o.timer = time.AfterFunc(ttl, func() {
+			o.mu.Lock()
+			o.enabled = false
+			o.mu.Unlock()
+			slog.Info("admin: override expired", "ttl", ttl)
+		})
+	}
+}
+
+func (o *overrideState) get() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.enabled
+}
+
+// adminAPI exposes the authenticated /admin/* routes used for runtime
+// control: toggling the threshold override, forcing an out-of-cycle
+// poll, adjusting a path's threshold, and draining the poll pipeline
+// for a clean re-exec.
+type adminAPI struct {
+	token     string
+	paths     map[string]*Path // by label
+	pending   chan<- *Path
+	restartCh chan<- struct{}
+	override  *overrideState
+}
+
+func newAdminAPI(token string, monitored []*Path, pending chan<- *Path, restartCh chan<- struct{}, override *overrideState) *adminAPI {
+	byLabel := make(map[string]*Path, len(monitored))
+	for _, p := range monitored {
+		byLabel[p.label] = p
+	}
+	return &adminAPI{token: token, paths: byLabel, pending: pending, restartCh: restartCh, override: override}
+}
+
+// RegisterRoutes mounts the admin subrouter, guarded by authenticate,
+// onto r.
+func (a *adminAPI) RegisterRoutes(r *mux.Router) {
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(a.authenticate)
+	admin.HandleFunc("/override", a.handleOverride).Methods("POST")
+	admin.HandleFunc("/poll", a.handlePoll).Methods("POST")
+	admin.HandleFunc("/threshold", a.handleThreshold).Methods("POST")
+	admin.HandleFunc("/restart", a.handleRestart).Methods("POST")
+}
+
+// authenticate requires a `Bearer <token>` Authorization header
+// matching -admin-token. The admin API is disabled entirely when
+// -admin-token is empty.
+func (a *adminAPI) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.token == "" {
+			http.Error(w, "admin API disabled: -admin-token not set", http.StatusForbidden)
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if got == "" || got != a.token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// overrideRequest is the body of POST /admin/override. TTL is a
+// time.ParseDuration string; an empty TTL leaves the override enabled
+// until explicitly toggled off again.
+type overrideRequest struct {
+	Enabled bool   `json:"enabled"`
+	TTL     string `json:"ttl"`
+}
+
+func (a *adminAPI) handleOverride(w http.ResponseWriter, r *http.Request) {
+	var req overrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %v", err), http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid ttl: %v", err), http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	a.override.set(req.Enabled, ttl)
+	slog.Info("admin: override set", "enabled", req.Enabled, "ttl", req.TTL)
+	fmt.Fprintf(w, "OK: override=%v ttl=%s\n", req.Enabled, req.TTL)
+}
+
+// pollRequest is the body of POST /admin/poll.
+type pollRequest struct {
+	Label string `json:"label"`
+}
+
+func (a *adminAPI) handlePoll(w http.ResponseWriter, r *http.Request) {
+	var req pollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %v", err), http.StatusBadRequest)
+		return
+	}
+	p, ok := a.paths[req.Label]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown path label %q", req.Label), http.StatusNotFound)
+		return
+	}
+	// Enqueue outside the handler goroutine if a Poller isn't
+	// immediately free, so the caller doesn't block on a busy pipeline.
+	// Like Path.Sleep, bail out if a restart has started draining the
+	// pipeline: pending is closed once draining completes, and sending
+	// on it would panic. restarting.Load() can still flip true between
+	// the check and the send, so also recover from that panic rather
+	// than trust the check alone to close the race.
+	select {
+	case a.pending <- p:
+	default:
+		go func() {
+			defer func() {
+				if rec := recover(); rec != nil {
+					slog.Info("admin: poll dropped, pipeline draining for restart", "label", req.Label)
+				}
+			}()
+			if restarting.Load() {
+				return
+			}
+			a.pending <- p
+		}()
+	}
+	fmt.Fprintf(w, "OK: poll enqueued for %s\n", req.Label)
+}
+
+// thresholdRequest is the body of POST /admin/threshold.
+type thresholdRequest struct {
+	Label     string `json:"label"`
+	Threshold uint64 `json:"threshold"`
+}
+
+func (a *adminAPI) handleThreshold(w http.ResponseWriter, r *http.Request) {
+	var req thresholdRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %v", err), http.StatusBadRequest)
+		return
+	}
+	p, ok := a.paths[req.Label]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown path label %q", req.Label), http.StatusNotFound)
+		return
+	}
+	p.threshold.Store(req.Threshold)
+	slog.Info("admin: threshold set", "label", req.Label, "threshold", req.Threshold)
+	fmt.Fprintf(w, "OK: threshold for %s set to %d\n", req.Label, req.Threshold)
+}
+
+func (a *adminAPI) handleRestart(w http.ResponseWriter, r *http.Request) {
+	select {
+	case a.restartCh <- struct{}{}:
+		fmt.Fprintln(w, "OK: restart scheduled, draining poll pipeline")
+	default:
+		fmt.Fprintln(w, "OK: restart already in progress")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultPath is the path monitored when neither -path nor -config is
+// set.
+const defaultPath = "/mnt/storage"
+
+// pathFlag is one -path flag value, in the form
+// "path[:label[:threshold[:interval]]]", e.g.
+//
+//	-path=/mnt/storage:storage:107374182400:30s
+//
+// Any of label, threshold or interval may be left empty to fall back
+// to the -threshold flag, pollInterval and the path's base name
+// respectively.
+type pathFlag struct {
+	path      string
+	label     string
+	threshold uint64
+	interval  time.Duration
+}
+
+// pathFlags collects repeated -path flags.
+type pathFlags []pathFlag
+
+func (p *pathFlags) String() string {
+	paths := make([]string, len(*p))
+	for i, pf := range *p {
+		paths[i] = pf.path
+	}
+	return strings.Join(paths, ",")
+}
+
+func (p *pathFlags) Set(value string) error {
+	parts := strings.Split(value, ":")
+
+	pf := pathFlag{
+		path:      parts[0],
+		label:     filepath.Base(parts[0]),
+		threshold: *threshold,
+		interval:  pollInterval,
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		pf.label = parts[1]
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		t, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid threshold in -path %q: %w", value, err)
+		}
+		pf.threshold = t
+	}
+	if len(parts) > 3 && parts[3] != "" {
+		d, err := time.ParseDuration(parts[3])
+		if err != nil {
+			return fmt.Errorf("invalid interval in -path %q: %w", value, err)
+		}
+		pf.interval = d
+	}
+
+	*p = append(*p, pf)
+	return nil
+}
+
+// fileConfig is the shape of the YAML file accepted by -config, an
+// alternative to repeated -path flags for larger deployments.
+type fileConfig struct {
+	Paths []struct {
+		Path      string `yaml:"path"`
+		Label     string `yaml:"label"`
+		Threshold uint64 `yaml:"threshold"`
+		Interval  string `yaml:"interval"`
+	} `yaml:"paths"`
+}
+
+// loadPathConfig turns the -path/-config flags into the list of Paths
+// to monitor. -config takes precedence; if neither is set it falls
+// back to the single -path/-threshold flags for backwards compatibility.
+func loadPathConfig(flags pathFlags, configFile string) ([]*Path, error) {
+	if configFile != "" {
+		return loadPathConfigFile(configFile)
+	}
+
+	if len(flags) == 0 {
+		flags = pathFlags{{path: defaultPath, label: filepath.Base(defaultPath), threshold: *threshold, interval: pollInterval}}
+	}
+
+	paths := make([]*Path, 0, len(flags))
+	for _, pf := range flags {
+		paths = append(paths, newPath(pf.path, pf.label, pf.threshold, pf.interval))
+	}
+	if err := rejectDuplicateLabels(paths); err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// rejectDuplicateLabels returns an error if two paths share a label.
+// The label is the key diskStatus.state and adminAPI.paths are keyed
+// by, so a collision (e.g. two -path entries both defaulting to the
+// same filepath.Base) would silently lose one path's status and leave
+// /readyz waiting on a path it can never hear from again.
+func rejectDuplicateLabels(paths []*Path) error {
+	seen := make(map[string]string, len(paths))
+	for _, p := range paths {
+		if other, dup := seen[p.label]; dup {
+			return fmt.Errorf("duplicate label %q for paths %q and %q", p.label, other, p.path)
+		}
+		seen[p.label] = p.path
+	}
+	return nil
+}
+
+// loadPathConfigFile parses configFile into the list of Paths to
+// monitor. It returns an error if the file defines no paths at all,
+// since main indexes monitored[0] to find the path that answers the
+// legacy "/" HAProxy check.
+func loadPathConfigFile(configFile string) ([]*Path, error) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", configFile, err)
+	}
+
+	var cfg fileConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", configFile, err)
+	}
+	if len(cfg.Paths) == 0 {
+		return nil, fmt.Errorf("%s: no paths configured", configFile)
+	}
+
+	paths := make([]*Path, 0, len(cfg.Paths))
+	for _, p := range cfg.Paths {
+		label := p.Label
+		if label == "" {
+			label = filepath.Base(p.Path)
+		}
+		th := p.Threshold
+		if th == 0 {
+			th = *threshold
+		}
+		interval := pollInterval
+		if p.Interval != "" {
+			d, err := time.ParseDuration(p.Interval)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interval for path %q: %w", p.Path, err)
+			}
+			interval = d
+		}
+		paths = append(paths, newPath(p.Path, label, th, interval))
+	}
+	if err := rejectDuplicateLabels(paths); err != nil {
+		return nil, fmt.Errorf("%s: %w", configFile, err)
+	}
+	return paths, nil
+}
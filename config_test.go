@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPathFlagsSet(t *testing.T) {
+	cases := []struct {
+		name      string
+		value     string
+		wantErr   bool
+		wantLabel string
+		wantTh    uint64
+		wantIval  time.Duration
+	}{
+		{name: "path only", value: "/mnt/storage", wantLabel: "storage", wantTh: *threshold, wantIval: pollInterval},
+		{name: "path and label", value: "/mnt/a:custom", wantLabel: "custom", wantTh: *threshold, wantIval: pollInterval},
+		{name: "path label threshold interval", value: "/mnt/a:custom:123:30s", wantLabel: "custom", wantTh: 123, wantIval: 30 * time.Second},
+		{name: "empty label falls back to base", value: "/mnt/a::123", wantLabel: "a", wantTh: 123, wantIval: pollInterval},
+		{name: "bad threshold", value: "/mnt/a:custom:notanumber", wantErr: true},
+		{name: "bad interval", value: "/mnt/a:custom:123:notaduration", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var flags pathFlags
+			err := flags.Set(tc.value)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Set(%q) = nil error, want one", tc.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Set(%q): %v", tc.value, err)
+			}
+			got := flags[0]
+			if got.label != tc.wantLabel {
+				t.Errorf("label = %q, want %q", got.label, tc.wantLabel)
+			}
+			if got.threshold != tc.wantTh {
+				t.Errorf("threshold = %d, want %d", got.threshold, tc.wantTh)
+			}
+			if got.interval != tc.wantIval {
+				t.Errorf("interval = %s, want %s", got.interval, tc.wantIval)
+			}
+		})
+	}
+}
+
+func TestRejectDuplicateLabels(t *testing.T) {
+	unique := []*Path{newPath("/mnt/a", "a", 1, time.Second), newPath("/mnt/b", "b", 1, time.Second)}
+	if err := rejectDuplicateLabels(unique); err != nil {
+		t.Fatalf("rejectDuplicateLabels(unique) = %v, want nil", err)
+	}
+
+	dup := []*Path{newPath("/mnt/a/data", "data", 1, time.Second), newPath("/mnt/b/data", "data", 1, time.Second)}
+	if err := rejectDuplicateLabels(dup); err == nil {
+		t.Fatal("rejectDuplicateLabels(dup) = nil, want an error naming the collision")
+	}
+}
+
+func TestLoadPathConfigFile(t *testing.T) {
+	write := func(t *testing.T, contents string) string {
+		t.Helper()
+		f := filepath.Join(t.TempDir(), "cfg.yaml")
+		if err := os.WriteFile(f, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	t.Run("empty paths list is an error", func(t *testing.T) {
+		f := write(t, "paths: []\n")
+		if _, err := loadPathConfigFile(f); err == nil {
+			t.Fatal("loadPathConfigFile(empty) = nil error, want one")
+		}
+	})
+
+	t.Run("missing paths key is an error", func(t *testing.T) {
+		f := write(t, "unrelated: true\n")
+		if _, err := loadPathConfigFile(f); err == nil {
+			t.Fatal("loadPathConfigFile(no paths key) = nil error, want one")
+		}
+	})
+
+	t.Run("duplicate labels rejected", func(t *testing.T) {
+		f := write(t, "paths:\n  - path: /mnt/a/data\n  - path: /mnt/b/data\n")
+		if _, err := loadPathConfigFile(f); err == nil {
+			t.Fatal("loadPathConfigFile(colliding labels) = nil error, want one")
+		}
+	})
+
+	t.Run("valid config parses", func(t *testing.T) {
+		f := write(t, "paths:\n  - path: /mnt/a\n    label: a\n    threshold: 5\n    interval: 30s\n  - path: /mnt/b\n")
+		paths, err := loadPathConfigFile(f)
+		if err != nil {
+			t.Fatalf("loadPathConfigFile: %v", err)
+		}
+		if len(paths) != 2 {
+			t.Fatalf("len(paths) = %d, want 2", len(paths))
+		}
+		if paths[0].label != "a" || paths[0].threshold.Load() != 5 || paths[0].interval != 30*time.Second {
+			t.Errorf("paths[0] = %+v, unexpected", paths[0])
+		}
+		if paths[1].label != "b" {
+			t.Errorf("paths[1].label = %q, want %q (fallback to filepath.Base)", paths[1].label, "b")
+		}
+	})
+
+	t.Run("bad interval is an error", func(t *testing.T) {
+		f := write(t, "paths:\n  - path: /mnt/a\n    interval: notaduration\n")
+		if _, err := loadPathConfigFile(f); err == nil {
+			t.Fatal("loadPathConfigFile(bad interval) = nil error, want one")
+		}
+	})
+}
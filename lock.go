@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/gofrs/flock"
+)
+
+// acquirePIDFile takes an exclusive advisory lock on pidPath and
+// writes the current PID into it, refusing to start if another
+// instance of this daemon already holds the lock. This stops process
+// supervisors (systemd restart storms, misconfigured runit, etc.)
+// from running two concurrent scans of the same path and doubling
+// I/O load. The caller must invoke the returned release func before
+// the process exits or re-execs.
+func acquirePIDFile(pidPath string) (release func(), err error) {
+	fl := flock.New(pidPath)
+	locked, err := fl.TryLock()
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %w", pidPath, err)
+	}
+	if !locked {
+		return nil, fmt.Errorf("%s is held by another instance of this daemon", pidPath)
+	}
+
+	if err := os.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())), 0o644); err != nil {
+		fl.Unlock()
+		return nil, fmt.Errorf("writing pid to %s: %w", pidPath, err)
+	}
+
+	return func() {
+		if err := fl.Unlock(); err != nil {
+			slog.Error("releasing pidfile lock", "path", pidPath, "error", err)
+		}
+	}, nil
+}
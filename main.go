@@ -1,14 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"fmt"
-	"log"
-	"net/http"
-	"os/exec"
-	"strconv"
-	"strings"
+	"log/slog"
+	"os"
+	"os/signal"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -17,161 +18,261 @@ const (
 	pollInterval   = 60 * time.Second
 	statusInterval = 1 * time.Minute
 	errTimeout     = 10 * time.Second
+
+	// livenessWindowFactor bounds how many poll intervals may pass
+	// without a completed poll before /livez starts failing.
+	livenessWindowFactor = 3
 )
 
 var (
-	threshold = flag.Uint64("threshold", uint64(1.074*10E11), "Threshold to start serving 500's over HTTP")
-	addr      = flag.String("addr", ":8080", "Listen address for HTTP")
-	path      = flag.String("path", "/mnt/storage", "The path to query for disk usage")
-	override  = flag.Bool("override", false, "Boolean to override check response for HTTP handler")
+	threshold     = flag.Uint64("threshold", uint64(1.074*10E11), "Default threshold (bytes) to start serving 500's over HTTP, used by -path entries that don't set their own")
+	addr          = flag.String("addr", ":8080", "Listen address for HTTP")
+	override      = flag.Bool("override", false, "Boolean to override check response for HTTP handler")
+	walkWorkers   = flag.Int("walk-workers", runtime.NumCPU(), "Number of concurrent goroutines used to walk a path's directory tree")
+	config        = flag.String("config", "", "YAML file describing the paths to monitor, an alternative to repeated -path flags")
+	adminToken    = flag.String("admin-token", "", "Bearer token required by /admin/* routes; admin API is disabled if unset")
+	shutdownGrace = flag.Duration("shutdown-grace", 10*time.Second, "How long to keep failing health checks after SIGTERM before shutting down, so HAProxy can drain")
+	pidFile       = flag.String("pidfile", "/var/run/haproxy-layer7-disk-check.pid", "PID file to exclusively lock, refusing to start if another instance holds it")
+	paths         pathFlags
 )
 
-// State represents the last-known state of a path
-// This is sent around between the Poller & StateMonitor's channels.
-type State struct {
-	path  string
-	bytes uint64
+func init() {
+	flag.Var(&paths, "path", "Path to monitor, in the form path[:label[:threshold[:interval]]]; may be repeated")
 }
 
-// Disk status storage, with an RWMutex for safe read/write access
-// across multiple goroutines
-type diskStatus struct {
-	state map[string]uint64
-	sync.RWMutex
-}
+// restarting is set once an admin-triggered restart starts draining
+// the poll pipeline, so in-flight Sleep calls stop resubmitting work.
+var restarting atomic.Bool
 
-// StateMonitor maintains a map that stores the disk usage for paths
-// being
-// polled, and prints the current state every updateInterval
-// nanoseconds.
-// It returns a chan State to which resource state should be sent.
-// It also serves a HTTP Handler Func for threshold checking. It's
-// probably doing too many things! :D
-func StateMonitor(updateInterval time.Duration) chan<- State {
-	updates := make(chan State)
-	diskStatus := &diskStatus{state: make(map[string]uint64)}
-	ticker := time.NewTicker(updateInterval)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				logState(diskStatus)
-			case s := <-updates:
-				// Write lock
-				diskStatus.Lock()
-				diskStatus.state[s.path] = s.bytes
-				diskStatus.Unlock()
-			}
-		}
-	}()
-	go func() {
-		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			// Read lock
-			diskStatus.RLock()
-			defer diskStatus.RUnlock()
-			bytes := diskStatus.state[*path]
-			switch {
-			case bytes == 0:
-				http.Error(w, "Disk status not cached yet", http.StatusServiceUnavailable)
-			case bytes > *threshold && *override == false:
-				err := fmt.Sprintf("ERROR: Bytes exceed threshold (%v/%v)", bytes, *threshold)
-				http.Error(w, err, http.StatusInternalServerError)
-			default:
-				fmt.Fprintf(w, "OK: %v is %v bytes; override set to %v\n", *path, bytes, *override)
-			}
-		})
-		if err := http.ListenAndServe(*addr, nil); err != nil {
-			log.Fatal("ListenAndServe failed: ", err)
-		}
-	}()
-	return updates
-}
+// shuttingDown is set once SIGTERM has been received, so health
+// checks fail immediately while HAProxy drains and -shutdown-grace
+// elapses.
+var shuttingDown atomic.Bool
 
-// logState prints a state map.
-func logState(ds *diskStatus) {
-	log.Println("Current state:")
-	// Read Lock
-	ds.RLock()
-	defer ds.RUnlock()
-	for k, v := range ds.state {
-		log.Printf(" %s %v", k, v)
-	}
-}
+// shutdownCtx is canceled the moment SIGTERM is received, so an
+// in-flight Poll stops walking promptly instead of running to
+// completion during the drain.
+var shutdownCtx, cancelShutdownCtx = context.WithCancel(context.Background())
+
+// pollerPanicked and lastPollUnixNano back /livez: a panicked poller
+// or a poll cycle that hasn't completed in too long both indicate the
+// process is stuck rather than merely unhealthy.
+var (
+	pollerPanicked   atomic.Bool
+	lastPollUnixNano atomic.Int64
+)
 
-// Path represents a filesystem directory to be polled with du and a
-// count of errors when interacting with said path
+// Path represents a filesystem directory to be polled, its own
+// threshold/interval/label, and a count of errors encountered walking
+// it. threshold is mutable at runtime via POST /admin/threshold while
+// Poll/Poller read it from a different goroutine, so it's stored
+// atomically; everything else is only ever set once, at startup.
 type Path struct {
-	path     string
-	errCount int
+	path      string
+	label     string
+	threshold atomic.Uint64
+	interval  time.Duration
+	errCount  int
 }
 
-// Poll executes du for a path
-// and returns the disk usage in bytes or an error string
-func (r *Path) Poll() (bytes uint64) {
-	out, err := exec.Command("du", "-sbx", r.path).Output()
-	if err != nil {
-		log.Fatal(err)
-		r.errCount++
-	}
-	// Tidy up the line
-	s := string(out)
-	s = strings.TrimSpace(s)
+// newPath constructs a Path with its initial threshold stored.
+func newPath(path, label string, threshold uint64, interval time.Duration) *Path {
+	p := &Path{path: path, label: label, interval: interval}
+	p.threshold.Store(threshold)
+	return p
+}
 
-	// Parse tabulation
-	bytesStr := strings.Split(s, "\t")[0]
+// Poll walks the path's directory tree natively (no more shelling out
+// to du) and returns the apparent size in bytes, matching `du -sbx`:
+// same device only, symlinks not followed, hardlinks counted once.
+// ctx lets a slow scan be canceled by shutdown. Permission errors on
+// subtrees are folded into r.errCount and counted in the
+// poll_errors_total metric rather than aborting the walk or killing
+// the process.
+func (r *Path) Poll(ctx context.Context) (bytes uint64) {
+	start := time.Now()
+	bytes, errCount, err := walkDisk(ctx, r.path, *walkWorkers)
+	duration := time.Since(start)
+	pollDuration.Observe(duration.Seconds())
 
-	// Parse uint64 from string
-	bytes, err = strconv.ParseUint(bytesStr, 0, 64)
-	if err != nil {
-		log.Fatal(err)
-		r.errCount++
+	r.errCount = errCount
+	if errCount > 0 {
+		pollErrors.Add(float64(errCount))
+	}
+
+	logArgs := []any{"path", r.path, "bytes", bytes, "duration", duration, "errCount", errCount}
+	if err != nil && err != context.Canceled {
+		slog.Warn("poll completed with error", append(logArgs, "error", err)...)
+	} else {
+		slog.Info("poll completed", logArgs...)
 	}
-	r.errCount = 0
 	return bytes
 }
 
 // Sleep sleeps for an appropriate interval (dependent on error state)
-// before sending the Path to done.
+// before sending the Path to done. It gives up without sending if a
+// restart has started draining the pipeline. restarting.Load() can
+// still flip true between the check and the send, racing main
+// closing done, so it also recovers from that panic rather than
+// trust the check alone to close the race: unlike an HTTP handler
+// goroutine, nothing else would catch a panic here and it would take
+// the whole process down.
 func (r *Path) Sleep(done chan<- *Path) {
-	time.Sleep(pollInterval + errTimeout*time.Duration(r.errCount))
+	time.Sleep(r.interval + errTimeout*time.Duration(r.errCount))
+	defer func() {
+		if rec := recover(); rec != nil {
+			slog.Info("poller: sleep send dropped, pipeline draining for restart", "path", r.path)
+		}
+	}()
+	if restarting.Load() {
+		return
+	}
 	done <- r
 }
 
 // Poller pulls paths off the input queue, runs Poll on the paths,
 // sends the output along the status channel then sends the path to
-// the complete channel
+// the complete channel. It's generalized to work for any configured
+// path rather than a single package-level one.
 func Poller(in <-chan *Path, out chan<- *Path, status chan<- State) {
 	for r := range in {
-		bytes := r.Poll()
-		status <- State{r.path, bytes}
+		if bytes, ok := safePoll(r); ok {
+			lastPollUnixNano.Store(time.Now().UnixNano())
+			status <- State{path: r.path, label: r.label, bytes: bytes, threshold: r.threshold.Load(), errCount: r.errCount}
+		}
 		out <- r
 	}
 }
 
+// safePoll runs r.Poll, recovering from a panic so one bad path can't
+// take the whole daemon down. pollerPanicked is latched for /livez to
+// report, since a poller that panicked mid-walk may be in a state we
+// no longer trust.
+func safePoll(r *Path) (bytes uint64, ok bool) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			pollerPanicked.Store(true)
+			slog.Error("poller: recovered from panic", "path", r.path, "panic", rec)
+			ok = false
+		}
+	}()
+	return r.Poll(shutdownCtx), true
+}
+
 func main() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
 	// parse CLI flags
 	flag.Parse()
+	overrideSt.set(*override, 0)
+
+	releasePIDFile, err := acquirePIDFile(*pidFile)
+	if err != nil {
+		slog.Error("acquiring pidfile", "error", err)
+		os.Exit(1)
+	}
+
+	monitored, err := loadPathConfig(paths, *config)
+	if err != nil {
+		slog.Error("loading path config", "error", err)
+		os.Exit(1)
+	}
 
 	// Create our input and output channels.
 	pending := make(chan *Path)
 	complete := make(chan *Path)
+	restartCh := make(chan struct{}, 1)
 
-	// Launch the StateMonitor.
-	status := StateMonitor(statusInterval)
-	log.Println("State Monitor started")
+	admin := newAdminAPI(*adminToken, monitored, pending, restartCh, overrideSt)
 
-	// Launch some Poller goroutines.
+	maxInterval := monitored[0].interval
+	for _, p := range monitored {
+		if p.interval > maxInterval {
+			maxInterval = p.interval
+		}
+	}
+
+	// Launch the StateMonitor. The first configured path answers the
+	// legacy "/" HAProxy check.
+	status, srv := StateMonitor(statusInterval, monitored[0].label, len(monitored), maxInterval*livenessWindowFactor, admin)
+	slog.Info("state monitor started")
+
+	// On SIGTERM, fail health checks immediately so HAProxy stops
+	// sending new requests, wait out -shutdown-grace for in-flight
+	// traffic to drain, then shut the HTTP server down cleanly.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		slog.Info("received SIGTERM, draining", "grace", *shutdownGrace)
+		shuttingDown.Store(true)
+		cancelShutdownCtx()
+		time.Sleep(*shutdownGrace)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Error("graceful shutdown", "error", err)
+		}
+		releasePIDFile()
+		os.Exit(0)
+	}()
+
+	// Launch some Poller goroutines, tracking when they've all
+	// drained out of `pending` so a restart can close `complete` once
+	// it's safe to do so.
+	var pollers sync.WaitGroup
 	for i := 0; i < numPollers; i++ {
-		go Poller(pending, complete, status)
+		pollers.Add(1)
+		go func() {
+			defer pollers.Done()
+			Poller(pending, complete, status)
+		}()
 	}
+	go func() {
+		pollers.Wait()
+		close(complete)
+	}()
 
-	// Send the path flag to the pending queue
+	// Send every configured path to the pending queue.
 	go func() {
-		pending <- &Path{path: *path}
+		for _, p := range monitored {
+			pending <- p
+		}
 	}()
 
-	for r := range complete {
-		go r.Sleep(pending)
+	for {
+		select {
+		case r, ok := <-complete:
+			if !ok {
+				restartProcess(releasePIDFile)
+				return
+			}
+			go r.Sleep(pending)
+		case <-restartCh:
+			slog.Info("admin: restart requested, draining poll pipeline")
+			restarting.Store(true)
+			close(pending)
+		}
 	}
+}
+
+// restartProcess re-execs the running binary with its original
+// argv/environment, so an operator can push a new build without
+// HAProxy ever losing its check target. Only called once the poll
+// pipeline has fully drained. releasePIDFile must run first: the
+// pidfile's flock is tied to this process's open file descriptor,
+// which survives exec, so the re-exec'd binary would otherwise
+// deadlock trying to re-acquire its own lock.
+func restartProcess(releasePIDFile func()) {
+	releasePIDFile()
 
+	exe, err := os.Executable()
+	if err != nil {
+		slog.Error("admin: restart failed, could not resolve executable", "error", err)
+		return
+	}
+	slog.Info("admin: poll pipeline drained, re-executing", "executable", exe)
+	if err := syscall.Exec(exe, os.Args, os.Environ()); err != nil {
+		slog.Error("admin: restart failed", "error", err)
+	}
 }
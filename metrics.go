@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics, all labeled by the monitored path (not its
+// label, which is just a routing convenience) so dashboards line up
+// with what an operator sees on disk.
+var (
+	bytesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "haproxy_disk_check_bytes",
+		Help: "Bytes on disk last observed for a monitored path.",
+	}, []string{"path"})
+
+	thresholdGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "haproxy_disk_check_threshold_bytes",
+		Help: "Configured byte threshold for a monitored path.",
+	}, []string{"path"})
+
+	overThresholdGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "haproxy_disk_check_over_threshold",
+		Help: "1 if a monitored path's bytes exceed its threshold (and override isn't set), else 0.",
+	}, []string{"path"})
+
+	lastPollGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "haproxy_disk_check_last_poll_timestamp_seconds",
+		Help: "Unix timestamp of the last completed poll for a monitored path.",
+	}, []string{"path"})
+
+	pollDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "haproxy_disk_check_poll_duration_seconds",
+		Help: "Time taken to walk a path's directory tree during a poll.",
+	})
+
+	pollErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "haproxy_disk_check_poll_errors_total",
+		Help: "Count of poll cycles that hit at least one subtree error.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(bytesGauge, thresholdGauge, overThresholdGauge, lastPollGauge, pollDuration, pollErrors)
+}
+
+// metricsHandler serves the registered collectors in Prometheus text
+// exposition format.
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
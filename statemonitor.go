@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// State represents the last-known state of a path.
+// This is sent around between the Poller & StateMonitor's channels.
+type State struct {
+	path      string
+	label     string
+	bytes     uint64
+	threshold uint64
+	errCount  int
+}
+
+// PathStatus is the last-known status of a single monitored path, as
+// served by GET /status.json.
+type PathStatus struct {
+	Path       string    `json:"path"`
+	Label      string    `json:"label"`
+	Bytes      uint64    `json:"bytes"`
+	Threshold  uint64    `json:"threshold"`
+	LastPolled time.Time `json:"lastPolled"`
+	ErrCount   int       `json:"errCount"`
+	Healthy    bool      `json:"healthy"`
+}
+
+// diskStatus is the shared table of path statuses, keyed by label,
+// with an RWMutex for safe read/write access across multiple
+// goroutines.
+type diskStatus struct {
+	state          map[string]*PathStatus
+	primaryLabel   string
+	totalPaths     int
+	livenessWindow time.Duration
+	sync.RWMutex
+}
+
+// ready reports whether every configured path has reported at least
+// one poll result, i.e. whether /readyz should start passing.
+func (ds *diskStatus) ready() bool {
+	ds.RLock()
+	defer ds.RUnlock()
+	return len(ds.state) >= ds.totalPaths
+}
+
+// StateMonitor maintains a table that stores the disk usage for every
+// path being polled, and prints the current state every
+// updateInterval. It returns a chan State to which poll results
+// should be sent, and the *http.Server so the caller can drive a
+// graceful shutdown. It serves the HTTP routes used for HAProxy's L7
+// check, Kubernetes-style liveness/readiness, and status/debug
+// introspection. It's probably doing too many things! :D
+func StateMonitor(updateInterval time.Duration, primaryLabel string, totalPaths int, livenessWindow time.Duration, admin *adminAPI) (chan<- State, *http.Server) {
+	updates := make(chan State)
+	ds := &diskStatus{
+		state:          make(map[string]*PathStatus),
+		primaryLabel:   primaryLabel,
+		totalPaths:     totalPaths,
+		livenessWindow: livenessWindow,
+	}
+	ticker := time.NewTicker(updateInterval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				logState(ds)
+			case s := <-updates:
+				over := s.bytes > s.threshold && !overrideSt.get()
+
+				// Write lock
+				ds.Lock()
+				ds.state[s.label] = &PathStatus{
+					Path:       s.path,
+					Label:      s.label,
+					Bytes:      s.bytes,
+					Threshold:  s.threshold,
+					LastPolled: time.Now(),
+					ErrCount:   s.errCount,
+					Healthy:    !over,
+				}
+				ds.Unlock()
+
+				bytesGauge.WithLabelValues(s.path).Set(float64(s.bytes))
+				thresholdGauge.WithLabelValues(s.path).Set(float64(s.threshold))
+				lastPollGauge.WithLabelValues(s.path).Set(float64(time.Now().Unix()))
+				if over {
+					overThresholdGauge.WithLabelValues(s.path).Set(1)
+				} else {
+					overThresholdGauge.WithLabelValues(s.path).Set(0)
+				}
+			}
+		}
+	}()
+	srv := &http.Server{Addr: *addr, Handler: MakeRESTRouter(ds, admin)}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("ListenAndServe failed", "error", err)
+		}
+	}()
+	return updates, srv
+}
+
+// MakeRESTRouter builds the HTTP routes this daemon serves: the
+// HAProxy L7 check (both the legacy "/" path and /check/{label} for
+// multi-path setups), Kubernetes-style /livez and /readyz, the JSON
+// status/debug endpoints, and, if admin is non-nil, the authenticated
+// /admin/* subrouter. Named in the spirit of the router Arvados
+// keepstore assembles the same way.
+func MakeRESTRouter(ds *diskStatus, admin *adminAPI) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/", ds.checkHandler(ds.primaryLabel)).Methods("GET")
+	r.HandleFunc("/check/{label}", ds.checkByLabelHandler).Methods("GET")
+	r.HandleFunc("/livez", ds.livezHandler).Methods("GET")
+	r.HandleFunc("/readyz", ds.readyzHandler).Methods("GET")
+	r.HandleFunc("/status.json", ds.statusHandler).Methods("GET")
+	r.HandleFunc("/debug.json", ds.debugHandler).Methods("GET")
+	r.Handle("/metrics", metricsHandler()).Methods("GET")
+	if admin != nil {
+		admin.RegisterRoutes(r)
+	}
+	return r
+}
+
+func (ds *diskStatus) checkByLabelHandler(w http.ResponseWriter, req *http.Request) {
+	ds.checkHandler(mux.Vars(req)["label"])(w, req)
+}
+
+// checkHandler returns a handler serving HAProxy's L7 threshold check
+// for a single path, identified by label. It returns 503 immediately
+// once shuttingDown is set, so HAProxy starts draining before the
+// process actually stops.
+func (ds *diskStatus) checkHandler(label string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if shuttingDown.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		ds.RLock()
+		s, ok := ds.state[label]
+		ds.RUnlock()
+		switch {
+		case !ok:
+			http.Error(w, fmt.Sprintf("Disk status not cached yet for %q", label), http.StatusServiceUnavailable)
+		case !s.Healthy:
+			http.Error(w, fmt.Sprintf("ERROR: Bytes exceed threshold (%v/%v)", s.Bytes, s.Threshold), http.StatusInternalServerError)
+		default:
+			fmt.Fprintf(w, "OK: %v is %v bytes; override set to %v\n", s.Path, s.Bytes, overrideSt.get())
+		}
+	}
+}
+
+// livezHandler is the Kubernetes liveness probe: it only fails if the
+// poller goroutines have panicked, or if no poll has completed in over
+// ds.livenessWindow, since either means the process is stuck rather
+// than merely unhealthy.
+func (ds *diskStatus) livezHandler(w http.ResponseWriter, r *http.Request) {
+	if pollerPanicked.Load() {
+		http.Error(w, "poller goroutine panicked", http.StatusServiceUnavailable)
+		return
+	}
+	if last := lastPollUnixNano.Load(); last != 0 {
+		if age := time.Since(time.Unix(0, last)); age > ds.livenessWindow {
+			http.Error(w, fmt.Sprintf("no successful poll in %s (limit %s)", age, ds.livenessWindow), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	fmt.Fprintln(w, "OK")
+}
+
+// readyzHandler is the Kubernetes readiness probe: it fails until
+// every configured path has populated the cache with at least one
+// poll, and immediately once shuttingDown is set.
+func (ds *diskStatus) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if shuttingDown.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	if !ds.ready() {
+		http.Error(w, "Disk status not cached yet", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "OK")
+}
+
+// statusHandler serves the status of every monitored path as JSON.
+func (ds *diskStatus) statusHandler(w http.ResponseWriter, r *http.Request) {
+	ds.RLock()
+	statuses := make([]*PathStatus, 0, len(ds.state))
+	for _, s := range ds.state {
+		statuses = append(statuses, s)
+	}
+	ds.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statuses)
+}
+
+// debugHandler serves runtime.MemStats and the current goroutine
+// count as JSON, for operators poking at a misbehaving instance.
+func (ds *diskStatus) debugHandler(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		MemStats   runtime.MemStats `json:"memStats"`
+		Goroutines int              `json:"goroutines"`
+	}{mem, runtime.NumGoroutine()})
+}
+
+// logState emits the current state of every monitored path as a
+// structured JSON log line.
+func logState(ds *diskStatus) {
+	// Read Lock
+	ds.RLock()
+	defer ds.RUnlock()
+	for _, s := range ds.state {
+		slog.Info("current state", "path", s.Path, "label", s.Label, "bytes", s.Bytes, "threshold", s.Threshold, "healthy", s.Healthy)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// diskWalker accumulates apparent byte usage for a directory tree the
+// same way `du -sbx` does: `-b` means apparent size (st_size), not
+// on-disk block allocation, `-x` means it stays on the device the root
+// path lives on, and it does not follow symlinks or double-count a
+// hardlinked inode. Work fans out across a bounded pool of goroutines
+// sized by sem's capacity.
+type diskWalker struct {
+	rootDev uint64
+	sem     chan struct{}
+
+	bytes    uint64
+	errCount int64
+
+	mu   sync.Mutex
+	seen map[uint64]map[uint64]struct{} // dev -> set of inodes already counted
+
+	wg sync.WaitGroup
+}
+
+// walkDisk walks root across workers goroutines and returns the total
+// apparent size in bytes (Size, matching `du -sb`, not Blocks*512) and
+// the number of subtrees that could not be read. The walk stops early
+// if ctx is canceled, in which case the partial totals are returned
+// alongside ctx.Err().
+func walkDisk(ctx context.Context, root string, workers int) (uint64, int, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	var rootStat syscall.Stat_t
+	if err := syscall.Lstat(root, &rootStat); err != nil {
+		return 0, 0, err
+	}
+
+	w := &diskWalker{
+		rootDev: rootStat.Dev,
+		sem:     make(chan struct{}, workers),
+		seen:    make(map[uint64]map[uint64]struct{}),
+	}
+	w.claim(rootStat.Dev, rootStat.Ino)
+	w.bytes = uint64(rootStat.Size)
+
+	w.wg.Add(1)
+	go w.walkDir(ctx, root)
+	w.wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return atomic.LoadUint64(&w.bytes), int(atomic.LoadInt64(&w.errCount)), err
+	}
+	return w.bytes, int(w.errCount), nil
+}
+
+// walkDir reads one directory, adds the apparent size of every entry
+// that hasn't been counted yet, and recurses into subdirectories. A
+// subdirectory is handed to a free worker when one is available and
+// walked inline otherwise, so fan-out never exceeds the pool size.
+func (w *diskWalker) walkDir(ctx context.Context, dir string) {
+	defer w.wg.Done()
+	if ctx.Err() != nil {
+		return
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		atomic.AddInt64(&w.errCount, 1)
+		return
+	}
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+
+		full := filepath.Join(dir, entry.Name())
+
+		var st syscall.Stat_t
+		if err := syscall.Lstat(full, &st); err != nil {
+			atomic.AddInt64(&w.errCount, 1)
+			continue
+		}
+		if uint64(st.Dev) != w.rootDev {
+			continue // crossed a mount point, like du -x
+		}
+		if !w.claim(uint64(st.Dev), st.Ino) {
+			continue // already counted this hardlink
+		}
+		atomic.AddUint64(&w.bytes, uint64(st.Size))
+
+		if !entry.IsDir() {
+			continue
+		}
+
+		w.wg.Add(1)
+		select {
+		case w.sem <- struct{}{}:
+			go func() {
+				defer func() { <-w.sem }()
+				w.walkDir(ctx, full)
+			}()
+		case <-ctx.Done():
+			w.wg.Done()
+			return
+		default:
+			// Pool saturated: walk this subdirectory inline rather
+			// than blocking on a free worker.
+			w.walkDir(ctx, full)
+		}
+	}
+}
+
+// claim records dev/ino as counted and reports whether this is the
+// first time it has been seen, so hardlinked files are only billed
+// once per device.
+func (w *diskWalker) claim(dev, ino uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	inodes, ok := w.seen[dev]
+	if !ok {
+		inodes = make(map[uint64]struct{})
+		w.seen[dev] = inodes
+	}
+	if _, dup := inodes[ino]; dup {
+		return false
+	}
+	inodes[ino] = struct{}{}
+	return true
+}